@@ -91,6 +91,7 @@ func RegisterAggregate(factory func(UUID) Aggregate) {
 		panic(fmt.Sprintf("eventhorizon: registering duplicate types for %q", aggregateType))
 	}
 	aggregates[aggregateType] = factory
+	aggregateHandlers[aggregateType] = indexEventHandlers(aggregate)
 }
 
 // CreateAggregate creates an aggregate of a type with an ID using the factory
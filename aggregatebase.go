@@ -0,0 +1,208 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// ErrNoEventHandler is returned by AggregateBase.DispatchEvent when no
+// Apply<EventType>/On<EventType> method was found for an event, and the
+// aggregate has not been configured to ignore unknown events via
+// IgnoreUnknownEvents.
+var ErrNoEventHandler = fmt.Errorf("eventhorizon: no event handler found for event")
+
+var (
+	ctxType       = reflect.TypeOf((*context.Context)(nil)).Elem()
+	eventType     = reflect.TypeOf((*Event)(nil)).Elem()
+	eventDataType = reflect.TypeOf((*EventData)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// eventHandlerKind distinguishes the two supported auto-dispatch method
+// shapes.
+type eventHandlerKind int
+
+const (
+	// applyHandler methods have the shape Apply<Name>(context.Context, EventData) error.
+	applyHandler eventHandlerKind = iota
+	// onHandler methods have the shape On<Name>(Event).
+	onHandler
+)
+
+// eventHandler is the cached reflection metadata for a single
+// Apply<Name>/On<Name> method, resolved once at RegisterAggregate time.
+type eventHandler struct {
+	method reflect.Method
+	kind   eventHandlerKind
+}
+
+// aggregateHandlers caches, per AggregateType, the EventType -> eventHandler
+// mapping discovered by reflecting over the concrete aggregate at
+// RegisterAggregate time. It is guarded by aggregatesMu, alongside the
+// factories it is built from.
+var aggregateHandlers = make(map[AggregateType]map[EventType]eventHandler)
+
+// indexEventHandlers reflects over the concrete type of aggregate and
+// indexes its Apply<EventType>(context.Context, EventData) error and
+// On<EventType)(Event) methods by the EventType named in the method name.
+func indexEventHandlers(aggregate Aggregate) map[EventType]eventHandler {
+	handlers := map[EventType]eventHandler{}
+
+	t := reflect.TypeOf(aggregate)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+
+		switch {
+		case len(m.Name) > len("Apply") && m.Name[:len("Apply")] == "Apply":
+			if m.Type.NumIn() != 3 || m.Type.NumOut() != 1 {
+				continue
+			}
+			if m.Type.In(1) != ctxType || !m.Type.In(2).Implements(eventDataType) || m.Type.Out(0) != errorType {
+				continue
+			}
+			handlers[EventType(m.Name[len("Apply"):])] = eventHandler{method: m, kind: applyHandler}
+
+		case len(m.Name) > len("On") && m.Name[:len("On")] == "On":
+			if m.Type.NumIn() != 2 || m.Type.NumOut() != 0 {
+				continue
+			}
+			if !m.Type.In(1).Implements(eventType) {
+				continue
+			}
+			handlers[EventType(m.Name[len("On"):])] = eventHandler{method: m, kind: onHandler}
+		}
+	}
+
+	return handlers
+}
+
+// handlersFor returns the cached handler map for an aggregate type, if it
+// has been registered.
+func handlersFor(aggregateType AggregateType) (map[EventType]eventHandler, bool) {
+	aggregatesMu.RLock()
+	defer aggregatesMu.RUnlock()
+	h, ok := aggregateHandlers[aggregateType]
+	return h, ok
+}
+
+// DispatchEvent looks up the Apply<EventType>/On<EventType> method indexed
+// for self's aggregate type and invokes it with event, via the cached
+// reflect.Method. If no handler matches, it returns ErrNoEventHandler unless
+// ignoreUnknownEvents was set with IgnoreUnknownEvents(true).
+//
+// Concrete aggregates embedding AggregateBase get auto-dispatch by calling
+// DispatchEvent(ctx, self, event) from their own ApplyEvent, passing
+// themselves as self so the cached reflect.Method can be invoked against the
+// concrete receiver; self must be the same value the aggregate was loaded or
+// created as. Aggregates that prefer a hand-written switch can ignore this
+// and implement ApplyEvent without it.
+func (a *AggregateBase) DispatchEvent(ctx context.Context, self Aggregate, event Event) error {
+	handlers, ok := handlersFor(self.AggregateType())
+	if !ok {
+		handlers = nil
+	}
+
+	handler, ok := handlers[event.EventType()]
+	if !ok {
+		if a.ignoreUnknownEvents {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrNoEventHandler, event.EventType())
+	}
+
+	receiver := reflect.ValueOf(self)
+
+	switch handler.kind {
+	case applyHandler:
+		out := handler.method.Func.Call([]reflect.Value{
+			receiver,
+			reflect.ValueOf(ctx),
+			reflect.ValueOf(event.Data()),
+		})
+		if err, ok := out[0].Interface().(error); ok && err != nil {
+			return err
+		}
+		return nil
+	case onHandler:
+		handler.method.Func.Call([]reflect.Value{
+			receiver,
+			reflect.ValueOf(event),
+		})
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrNoEventHandler, event.EventType())
+	}
+}
+
+// IgnoreUnknownEvents configures whether DispatchEvent should silently
+// ignore events with no matching Apply<EventType>/On<EventType> method,
+// instead of returning ErrNoEventHandler. This is useful for forward
+// compatibility when replaying streams written by a newer version of the
+// aggregate.
+func (a *AggregateBase) IgnoreUnknownEvents(ignore bool) {
+	a.ignoreUnknownEvents = ignore
+}
+
+// SafeApplyEvent verifies that event.Version() immediately follows a's
+// current version before delegating to self.ApplyEvent, returning
+// ErrEventVersionMismatch otherwise. EventStoreRepository.Load calls this
+// automatically for every aggregate embedding *AggregateBase (see
+// applyEvent), so replaying a stream that missed or reordered events fails
+// fast with a typed error instead of silently applying events out of
+// sequence.
+func (a *AggregateBase) SafeApplyEvent(ctx context.Context, self Aggregate, event Event) error {
+	if expected := a.Version() + 1; event.Version() != expected {
+		return ErrEventVersionMismatch{
+			AggregateType: a.AggregateType(),
+			ID:            a.AggregateID(),
+			Expected:      expected,
+			Actual:        event.Version(),
+		}
+	}
+
+	return self.ApplyEvent(ctx, event)
+}
+
+// versionSafeApplier is implemented by aggregates embedding *AggregateBase.
+// Repository replay loops use it in place of a plain ApplyEvent call so
+// every aggregate embedding AggregateBase gets ErrEventVersionMismatch
+// protection during replay for free, without its author having to remember
+// to call SafeApplyEvent by hand.
+type versionSafeApplier interface {
+	SafeApplyEvent(ctx context.Context, self Aggregate, event Event) error
+}
+
+// applyEvent applies event to aggregate, routing through SafeApplyEvent (and
+// so through its version-sequencing check) when aggregate embeds
+// AggregateBase, falling back to a plain ApplyEvent call otherwise.
+func applyEvent(ctx context.Context, aggregate Aggregate, event Event) error {
+	if safe, ok := aggregate.(versionSafeApplier); ok {
+		return safe.SafeApplyEvent(ctx, aggregate, event)
+	}
+	return aggregate.ApplyEvent(ctx, event)
+}
+
+// ApplyEvent applies event to aggregate the same way EventStoreRepository.Load
+// replays events: through SafeApplyEvent (and so through its
+// version-sequencing check) when aggregate embeds AggregateBase, falling
+// back to a plain ApplyEvent call otherwise. It is exported so that
+// middleware and external repositories can replay events consistently with
+// this package's own Repository.
+func ApplyEvent(ctx context.Context, aggregate Aggregate, event Event) error {
+	return applyEvent(ctx, aggregate, event)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"testing"
+)
+
+// recorder is shared by value- and pointer-receiver handlers below so both
+// can prove they ran, even though a value receiver only ever sees a copy of
+// the aggregate.
+type recorder struct {
+	calls []string
+}
+
+type fooHappened struct {
+	Value string
+}
+
+type barHappened struct {
+	Value string
+}
+
+type auditedHappened struct {
+	Value string
+}
+
+// auditMixin is embedded into aggregates to check that On<EventType> methods
+// promoted from an embedded (non-AggregateBase) type are still indexed.
+type auditMixin struct {
+	rec *recorder
+}
+
+func (m auditMixin) OnAudited(event Event) {
+	m.rec.calls = append(m.rec.calls, "audit:"+string(event.EventType()))
+}
+
+// reflectTestAggregate embeds *AggregateBase (the common pattern) plus
+// auditMixin, and mixes a pointer-receiver Apply method with a
+// value-receiver one to exercise both receiver kinds through the same
+// reflection cache.
+type reflectTestAggregate struct {
+	*AggregateBase
+	auditMixin
+
+	rec *recorder
+}
+
+func newReflectTestAggregate(aggregateType AggregateType) func(UUID) Aggregate {
+	return func(id UUID) Aggregate {
+		rec := &recorder{}
+		return &reflectTestAggregate{
+			AggregateBase: NewAggregateBase(aggregateType, id),
+			auditMixin:    auditMixin{rec: rec},
+			rec:           rec,
+		}
+	}
+}
+
+// ApplyFoo has a pointer receiver and mutates the aggregate directly.
+func (a *reflectTestAggregate) ApplyFoo(ctx context.Context, data EventData) error {
+	a.rec.calls = append(a.rec.calls, "foo:"+data.(fooHappened).Value)
+	return nil
+}
+
+// ApplyBar has a value receiver: the receiver itself is a copy, so it can
+// only prove it ran via the shared *recorder field.
+func (a reflectTestAggregate) ApplyBar(ctx context.Context, data EventData) error {
+	a.rec.calls = append(a.rec.calls, "bar:"+data.(barHappened).Value)
+	return nil
+}
+
+func (a *reflectTestAggregate) HandleCommand(ctx context.Context, cmd Command) error {
+	return nil
+}
+
+func (a *reflectTestAggregate) ApplyEvent(ctx context.Context, event Event) error {
+	return a.DispatchEvent(ctx, a, event)
+}
+
+func newTestEvent(t EventType, data EventData) Event {
+	return NewEvent(t, data, NewUUID())
+}
+
+func TestIndexEventHandlers_PointerAndValueReceivers(t *testing.T) {
+	const aggregateType = AggregateType("ReflectTestAggregate_PointerAndValue")
+	RegisterAggregate(newReflectTestAggregate(aggregateType))
+
+	aggregate, err := CreateAggregate(aggregateType, NewUUID())
+	if err != nil {
+		t.Fatalf("CreateAggregate: %v", err)
+	}
+
+	if err := aggregate.ApplyEvent(context.Background(), newTestEvent("Foo", fooHappened{Value: "a"})); err != nil {
+		t.Fatalf("apply Foo: %v", err)
+	}
+	if err := aggregate.ApplyEvent(context.Background(), newTestEvent("Bar", barHappened{Value: "b"})); err != nil {
+		t.Fatalf("apply Bar: %v", err)
+	}
+
+	rec := aggregate.(*reflectTestAggregate).rec
+	want := []string{"foo:a", "bar:b"}
+	if len(rec.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", rec.calls, want)
+	}
+	for i, c := range want {
+		if rec.calls[i] != c {
+			t.Errorf("calls[%d] = %q, want %q", i, rec.calls[i], c)
+		}
+	}
+}
+
+func TestIndexEventHandlers_EmbeddedOnHandler(t *testing.T) {
+	const aggregateType = AggregateType("ReflectTestAggregate_Embedded")
+	RegisterAggregate(newReflectTestAggregate(aggregateType))
+
+	aggregate, err := CreateAggregate(aggregateType, NewUUID())
+	if err != nil {
+		t.Fatalf("CreateAggregate: %v", err)
+	}
+
+	if err := aggregate.ApplyEvent(context.Background(), newTestEvent("Audited", auditedHappened{Value: "c"})); err != nil {
+		t.Fatalf("apply Audited: %v", err)
+	}
+
+	rec := aggregate.(*reflectTestAggregate).rec
+	if len(rec.calls) != 1 || rec.calls[0] != "audit:Audited" {
+		t.Fatalf("calls = %v, want [audit:Audited]", rec.calls)
+	}
+}
+
+func TestRegisterAggregate_HandlersIndexedRegardlessOfOrder(t *testing.T) {
+	const (
+		firstType  = AggregateType("ReflectTestAggregate_OrderFirst")
+		secondType = AggregateType("ReflectTestAggregate_OrderSecond")
+	)
+
+	// Register second before first, to check that indexing one aggregate
+	// type's handlers doesn't depend on registration order relative to
+	// other types, and that each type's cache stays independent.
+	RegisterAggregate(newReflectTestAggregate(secondType))
+	RegisterAggregate(newReflectTestAggregate(firstType))
+
+	for _, at := range []AggregateType{firstType, secondType} {
+		aggregate, err := CreateAggregate(at, NewUUID())
+		if err != nil {
+			t.Fatalf("CreateAggregate(%s): %v", at, err)
+		}
+		if err := aggregate.ApplyEvent(context.Background(), newTestEvent("Foo", fooHappened{Value: "x"})); err != nil {
+			t.Fatalf("apply Foo to %s: %v", at, err)
+		}
+		rec := aggregate.(*reflectTestAggregate).rec
+		if len(rec.calls) != 1 || rec.calls[0] != "foo:x" {
+			t.Errorf("%s calls = %v, want [foo:x]", at, rec.calls)
+		}
+	}
+}
+
+func TestDispatchEvent_NoHandler(t *testing.T) {
+	const aggregateType = AggregateType("ReflectTestAggregate_NoHandler")
+	RegisterAggregate(newReflectTestAggregate(aggregateType))
+
+	aggregate, err := CreateAggregate(aggregateType, NewUUID())
+	if err != nil {
+		t.Fatalf("CreateAggregate: %v", err)
+	}
+
+	err = aggregate.ApplyEvent(context.Background(), newTestEvent("Unknown", fooHappened{Value: "z"}))
+	if err == nil {
+		t.Fatal("expected ErrNoEventHandler, got nil")
+	}
+
+	aggregate.(*reflectTestAggregate).IgnoreUnknownEvents(true)
+	if err := aggregate.ApplyEvent(context.Background(), newTestEvent("Unknown", fooHappened{Value: "z"})); err != nil {
+		t.Fatalf("expected nil error after IgnoreUnknownEvents(true), got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"testing"
+)
+
+// TestSafeApplyEvent_RejectsVersionMismatch checks the version-sequencing
+// contract EventStoreRepository.Load relies on for every aggregate embedding
+// *AggregateBase: an event must immediately follow the aggregate's current
+// version, or SafeApplyEvent fails fast with ErrEventVersionMismatch instead
+// of silently applying an out-of-order or duplicated event.
+func TestSafeApplyEvent_RejectsVersionMismatch(t *testing.T) {
+	const aggregateType = AggregateType("ConcurrencyTestAggregate")
+	RegisterAggregate(newReflectTestAggregate(aggregateType))
+
+	aggregate, err := CreateAggregate(aggregateType, NewUUID())
+	if err != nil {
+		t.Fatalf("CreateAggregate: %v", err)
+	}
+	base := aggregate.(*reflectTestAggregate)
+
+	// A freshly created aggregate is at version 0, so it expects the next
+	// event's Version() to be 1. NewEvent does not accept a version, and
+	// defaults to something other than 1, so this event is out of sequence.
+	event := newTestEvent("Foo", fooHappened{Value: "a"})
+
+	err = base.SafeApplyEvent(context.Background(), aggregate, event)
+	mismatch, ok := err.(ErrEventVersionMismatch)
+	if !ok {
+		t.Fatalf("expected ErrEventVersionMismatch, got %T: %v", err, err)
+	}
+	if mismatch.Expected != 1 {
+		t.Errorf("mismatch.Expected = %d, want 1", mismatch.Expected)
+	}
+	if len(base.rec.calls) != 0 {
+		t.Errorf("ApplyFoo must not run on a version mismatch, got calls %v", base.rec.calls)
+	}
+}
+
+// TestEventStoreRepository_Save_AnyVersionSkipsConflictCheck checks that
+// passing AnyVersion opts out of Save's expectedVersion check, for callers that intentionally don't track the version they loaded at.
+func TestEventStoreRepository_Save_AnyVersionSkipsConflictCheck(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+
+	id := NewUUID()
+	aggregate := &testCounterAggregate{id: id}
+	aggregate.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 1})
+	aggregate.IncrementVersion()
+
+	// originalVersion is 0, which numerically differs from AnyVersion's -1 --
+	// Save must still accept it, since AnyVersion is a sentinel that skips
+	// the comparison entirely rather than a version that happens to match.
+	if err := repo.Save(ctx, aggregate, AnyVersion); err != nil {
+		t.Fatalf("Save with AnyVersion: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAggregateNotFound is returned when the aggregate loaded for a command
+// could not be found.
+var ErrAggregateNotFound = errors.New("aggregate not found")
+
+// ErrCommandRejectedSoftDeleted is returned when a command is dispatched to a
+// soft-deleted aggregate and the command's type is not allowlisted to act on
+// deleted aggregates.
+var ErrCommandRejectedSoftDeleted = errors.New("command rejected: aggregate is soft-deleted")
+
+// restoreCommands is the set of command types allowed to act on a
+// soft-deleted aggregate, registered via AllowCommandOnDeleted. These are
+// typically restore/undelete commands.
+var restoreCommands = make(map[CommandType]bool)
+var restoreCommandsMu sync.RWMutex
+
+// AllowCommandOnDeleted allowlists a command type so the Dispatcher will
+// still deliver it to a soft-deleted aggregate, instead of rejecting it with
+// ErrCommandRejectedSoftDeleted. This is meant for restore/undelete commands.
+func AllowCommandOnDeleted(commandType CommandType) {
+	restoreCommandsMu.Lock()
+	defer restoreCommandsMu.Unlock()
+	restoreCommands[commandType] = true
+}
+
+// commandAllowedOnDeleted reports whether commandType may be dispatched to a
+// soft-deleted aggregate.
+func commandAllowedOnDeleted(commandType CommandType) bool {
+	restoreCommandsMu.RLock()
+	defer restoreCommandsMu.RUnlock()
+	return restoreCommands[commandType]
+}
+
+// Dispatcher loads the aggregate for a command, checks it against soft-delete
+// rules, hands the command to it, and saves the resulting events.
+type Dispatcher struct {
+	repository Repository
+}
+
+// NewDispatcher creates a Dispatcher using repository to load and save
+// aggregates.
+func NewDispatcher(repository Repository) *Dispatcher {
+	return &Dispatcher{
+		repository: repository,
+	}
+}
+
+// Dispatch loads the aggregate targeted by the command, rejects the command
+// if the aggregate is soft-deleted and the command is not allowlisted via
+// AllowCommandOnDeleted, and otherwise calls HandleCommand and saves the
+// resulting events.
+//
+// The rejection decision is made entirely at the dispatcher layer: Load is
+// always called with IncludeDeleted(true) so that a soft-deleted aggregate
+// is handed to the Dispatcher instead of failing at the repository with
+// ErrAggregateSoftDeleted, and the Dispatcher itself decides whether the
+// command may proceed.
+func (d *Dispatcher) Dispatch(ctx context.Context, cmd Command) error {
+	aggregate, err := d.repository.Load(ctx, cmd.AggregateType(), cmd.AggregateID(), IncludeDeleted(true))
+	if err != nil {
+		return err
+	}
+	if aggregate == nil {
+		return ErrAggregateNotFound
+	}
+
+	if deleter, ok := aggregate.(SoftDeleter); ok {
+		if deleted, _ := deleter.Deleted(); deleted && !commandAllowedOnDeleted(cmd.CommandType()) {
+			return ErrCommandRejectedSoftDeleted
+		}
+	}
+
+	loadedVersion := aggregate.Version()
+
+	if err := aggregate.HandleCommand(ctx, cmd); err != nil {
+		return err
+	}
+
+	return d.repository.Save(ctx, aggregate, loadedVersion)
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// inMemoryEventStore is a minimal eh.EventStore test double, kept local to
+// this package since middleware compiles (and tests) independently of the
+// eventhorizon package's own in-memory double.
+type inMemoryEventStore struct {
+	mu      sync.Mutex
+	streams map[string][]eh.Event
+}
+
+func newInMemoryEventStore() *inMemoryEventStore {
+	return &inMemoryEventStore{streams: make(map[string][]eh.Event)}
+}
+
+func (s *inMemoryEventStore) Save(ctx context.Context, stream string, events []eh.Event, originalVersion int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing := s.streams[stream]
+	if len(existing) != originalVersion {
+		return fmt.Errorf("inMemoryEventStore: concurrent write to %q: expected version %d, actual %d",
+			stream, originalVersion, len(existing))
+	}
+	s.streams[stream] = append(existing, events...)
+	return nil
+}
+
+func (s *inMemoryEventStore) Load(ctx context.Context, stream string) ([]eh.Event, error) {
+	return s.LoadFrom(ctx, stream, 0)
+}
+
+func (s *inMemoryEventStore) LoadFrom(ctx context.Context, stream string, fromVersion int) ([]eh.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.streams[stream]
+	if fromVersion >= len(all) {
+		return nil, nil
+	}
+	events := make([]eh.Event, len(all)-fromVersion)
+	copy(events, all[fromVersion:])
+	return events, nil
+}
+
+// counterIncremented is the only event counterAggregate knows how to apply.
+type counterIncremented struct {
+	By int
+}
+
+const (
+	counterAggregateType   = eh.AggregateType("MiddlewareTestCounter")
+	counterIncrementedType = eh.EventType("Incremented")
+)
+
+// counterAggregate is a hand-written eh.Aggregate (it does not embed
+// *eh.AggregateBase) used to drive middleware without pulling in
+// reflection-based dispatch.
+type counterAggregate struct {
+	id      eh.UUID
+	version int
+	count   int
+	events  []eh.Event
+}
+
+func newCounterAggregate(id eh.UUID) eh.Aggregate {
+	return &counterAggregate{id: id}
+}
+
+func (a *counterAggregate) AggregateType() eh.AggregateType { return counterAggregateType }
+func (a *counterAggregate) AggregateID() eh.UUID             { return a.id }
+func (a *counterAggregate) Version() int                     { return a.version }
+func (a *counterAggregate) IncrementVersion()                 { a.version++ }
+
+// HandleCommand stores and immediately applies one Incremented event, the
+// way a concrete aggregate's own command handling is expected to leave the
+// aggregate's in-memory state and Version() already reflecting its new
+// uncommitted events.
+func (a *counterAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	event := a.StoreEvent(counterIncrementedType, counterIncremented{By: 1})
+	if err := a.ApplyEvent(ctx, event); err != nil {
+		return err
+	}
+	a.IncrementVersion()
+	return nil
+}
+
+func (a *counterAggregate) StoreEvent(t eh.EventType, data eh.EventData) eh.Event {
+	e := eh.NewEvent(t, data, a.id)
+	a.events = append(a.events, e)
+	return e
+}
+
+func (a *counterAggregate) UncommittedEvents() []eh.Event { return a.events }
+func (a *counterAggregate) ClearUncommittedEvents()        { a.events = nil }
+
+func (a *counterAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	data, ok := event.Data().(counterIncremented)
+	if !ok {
+		return fmt.Errorf("counterAggregate: unexpected event data %T", event.Data())
+	}
+	a.count += data.By
+	return nil
+}
+
+func (a *counterAggregate) SnapshotState() ([]byte, error) {
+	return []byte{byte(a.count)}, nil
+}
+
+func (a *counterAggregate) RestoreSnapshot(data []byte) error {
+	a.count = int(data[0])
+	return nil
+}
+
+type counterSnapshotCodec struct{}
+
+func (counterSnapshotCodec) Restore(id eh.UUID, data []byte) (eh.Aggregate, error) {
+	a := &counterAggregate{id: id}
+	if err := a.RestoreSnapshot(data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package middleware wraps an aggregate repository with cross-cutting
+// concerns so that each store need not reimplement them. It ships tracing,
+// snapshot read-through and optimistic-concurrency retry; other concerns,
+// such as logging or metrics, can be written as an AggregateMiddleware and
+// composed with Use.
+package middleware
+
+import (
+	"context"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// AggregateHandler loads and saves aggregates. eh.Repository does not
+// satisfy this interface directly, since its Load also takes LoadOptions;
+// use FromRepository to adapt one.
+type AggregateHandler interface {
+	// Load loads the aggregate of aggregateType with id.
+	Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error)
+	// Save saves the aggregate's uncommitted events, checked against
+	// expectedVersion exactly like eh.Repository.Save.
+	Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error
+}
+
+// AggregateMiddleware wraps an AggregateHandler with additional behavior.
+type AggregateMiddleware func(AggregateHandler) AggregateHandler
+
+// Use wraps handler with middleware, in the order given: the first
+// middleware is the outermost, so it sees a Load/Save call before any of the
+// others.
+//
+// An example would be:
+//     h := middleware.Use(handler, middleware.Tracing(tracer), middleware.Retry(3, time.Second, reapply))
+func Use(handler AggregateHandler, mw ...AggregateMiddleware) AggregateHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
+// repositoryHandler adapts an eh.Repository to the AggregateHandler
+// interface used by middleware, calling Load with no LoadOptions.
+type repositoryHandler struct {
+	repo eh.Repository
+}
+
+// FromRepository adapts repo to an AggregateHandler so it can be wrapped
+// with middleware via Use.
+func FromRepository(repo eh.Repository) AggregateHandler {
+	return repositoryHandler{repo: repo}
+}
+
+// Load implements the AggregateHandler interface.
+func (h repositoryHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	return h.repo.Load(ctx, aggregateType, id)
+}
+
+// Save implements the AggregateHandler interface.
+func (h repositoryHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	return h.repo.Save(ctx, aggregate, expectedVersion)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// versionConflictError is implemented by errors that represent an optimistic
+// concurrency conflict on Save, such as eh.ErrVersionConflict. Retry uses
+// this instead of a concrete type so it keeps working if new conflict error
+// types are added later.
+type versionConflictError interface {
+	error
+	VersionConflict() bool
+}
+
+// ReapplyFunc re-runs whatever produced aggregate's uncommitted events --
+// typically a command handler -- against the freshly reloaded aggregate
+// passed in, so Retry has new uncommitted events to resubmit after a
+// conflict. It must not call Save itself.
+//
+// An example would be:
+//     middleware.Retry(3, time.Second, func(ctx context.Context, aggregate eh.Aggregate) error {
+//         return aggregate.HandleCommand(ctx, cmd)
+//     })
+type ReapplyFunc func(ctx context.Context, aggregate eh.Aggregate) error
+
+// Retry returns an AggregateMiddleware that, when Save fails with an error
+// satisfying versionConflictError (such as eh.ErrVersionConflict), reloads
+// the aggregate, calls reapply on the fresh copy to regenerate its
+// uncommitted events, and resubmits -- up to attempts times, with
+// exponential backoff starting at backoff and doubling each attempt. All
+// other errors are returned immediately. Load is passed through unchanged.
+//
+// reapply must reproduce exactly the command or operation that generated
+// the aggregate's original uncommitted events; Retry has no way to recover
+// those once a conflict discards them.
+func Retry(attempts int, backoff time.Duration, reapply ReapplyFunc) AggregateMiddleware {
+	return func(next AggregateHandler) AggregateHandler {
+		return retryHandler{next: next, attempts: attempts, backoff: backoff, reapply: reapply}
+	}
+}
+
+type retryHandler struct {
+	next     AggregateHandler
+	attempts int
+	backoff  time.Duration
+	reapply  ReapplyFunc
+}
+
+// Load implements the AggregateHandler interface.
+func (h retryHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	return h.next.Load(ctx, aggregateType, id)
+}
+
+// Save implements the AggregateHandler interface.
+func (h retryHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	wait := h.backoff
+
+	var err error
+	for attempt := 0; attempt <= h.attempts; attempt++ {
+		err = h.next.Save(ctx, aggregate, expectedVersion)
+
+		conflict, ok := err.(versionConflictError)
+		if err == nil || !ok || !conflict.VersionConflict() || attempt == h.attempts {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+
+		fresh, loadErr := h.next.Load(ctx, aggregate.AggregateType(), aggregate.AggregateID())
+		if loadErr != nil {
+			return loadErr
+		}
+
+		expectedVersion = fresh.Version()
+		if err := h.reapply(ctx, fresh); err != nil {
+			return err
+		}
+		aggregate = fresh
+	}
+
+	return err
+}
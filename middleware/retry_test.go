@@ -0,0 +1,155 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// conflictingHandler is an AggregateHandler double backed by a single
+// "server-side" counterAggregate. Save enforces the same optimistic
+// concurrency an eh.Repository would: it fails with eh.ErrVersionConflict
+// whenever the version the caller's events were built on top of no longer
+// matches what is stored.
+type conflictingHandler struct {
+	mu       sync.Mutex
+	stored   *counterAggregate
+	attempts int
+}
+
+func (h *conflictingHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cp := *h.stored
+	cp.events = nil
+	return &cp, nil
+}
+
+func (h *conflictingHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts++
+
+	a := aggregate.(*counterAggregate)
+	events := a.UncommittedEvents()
+	originalVersion := a.Version() - len(events)
+
+	if expectedVersion != originalVersion {
+		return eh.ErrVersionConflict{
+			AggregateType: a.AggregateType(),
+			ID:            a.AggregateID(),
+			Expected:      expectedVersion,
+			Actual:        originalVersion,
+		}
+	}
+	if originalVersion != h.stored.Version() {
+		return eh.ErrVersionConflict{
+			AggregateType: a.AggregateType(),
+			ID:            a.AggregateID(),
+			Expected:      originalVersion,
+			Actual:        h.stored.Version(),
+		}
+	}
+
+	a.ClearUncommittedEvents()
+	h.stored = a
+	return nil
+}
+
+func TestRetry_ReloadsAndReappliesOnVersionConflict(t *testing.T) {
+	id := eh.NewUUID()
+	handler := &conflictingHandler{stored: &counterAggregate{id: id}}
+
+	reapply := func(ctx context.Context, aggregate eh.Aggregate) error {
+		return aggregate.HandleCommand(ctx, nil)
+	}
+
+	h := Use(handler, Retry(3, time.Millisecond, reapply))
+
+	aggregate, err := h.Load(context.Background(), counterAggregateType, id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	loadedVersion := aggregate.Version()
+
+	// Simulate another writer committing directly against the "server" in
+	// between this Load and the Save below, so the first Save attempt below
+	// conflicts.
+	handler.mu.Lock()
+	handler.stored = &counterAggregate{id: id, version: 1, count: 5}
+	handler.mu.Unlock()
+
+	if err := aggregate.HandleCommand(context.Background(), nil); err != nil {
+		t.Fatalf("HandleCommand: %v", err)
+	}
+
+	if err := h.Save(context.Background(), aggregate, loadedVersion); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if handler.attempts != 2 {
+		t.Fatalf("Save attempts = %d, want 2 (one conflict, one successful retry)", handler.attempts)
+	}
+	if v := handler.stored.Version(); v != 2 {
+		t.Errorf("stored version = %d, want 2", v)
+	}
+	if c := handler.stored.count; c != 6 {
+		t.Errorf("stored count = %d, want 6 (remote count 5 + 1 from the reapplied command)", c)
+	}
+}
+
+// erroringHandler always fails Save with a plain, non-conflict error.
+type erroringHandler struct {
+	saves int
+	err   error
+}
+
+func (h *erroringHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	return &counterAggregate{id: id}, nil
+}
+
+func (h *erroringHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	h.saves++
+	return h.err
+}
+
+func TestRetry_NonConflictErrorReturnsImmediately(t *testing.T) {
+	handler := &erroringHandler{err: errors.New("boom")}
+	reapplyCalled := false
+	reapply := func(ctx context.Context, aggregate eh.Aggregate) error {
+		reapplyCalled = true
+		return nil
+	}
+
+	h := Use(handler, Retry(3, time.Millisecond, reapply))
+
+	aggregate := &counterAggregate{id: eh.NewUUID()}
+	err := h.Save(context.Background(), aggregate, 0)
+	if !errors.Is(err, handler.err) {
+		t.Fatalf("Save err = %v, want %v", err, handler.err)
+	}
+	if handler.saves != 1 {
+		t.Errorf("saves = %d, want 1 (no retry for a non-conflict error)", handler.saves)
+	}
+	if reapplyCalled {
+		t.Error("reapply should not be called for a non-conflict error")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// Snapshot returns an AggregateMiddleware providing snapshot read-through on
+// top of any AggregateHandler, using eventStore, store and policy.
+//
+// On Load, when a snapshot exists in store and the aggregate type has a
+// SnapshotCodec registered via eh.RegisterSnapshotCodec, it restores the
+// snapshot and then replays only the events committed after the snapshot's
+// version from eventStore, the same read-through behavior as
+// eh.EventStoreRepository.Load. The wrapped handler is only consulted when
+// there is no usable snapshot.
+//
+// On Save, it delegates to the wrapped handler first and then, if policy
+// decides to, persists a new snapshot of the result.
+func Snapshot(eventStore eh.EventStore, store eh.SnapshotStore, policy eh.SnapshotPolicy) AggregateMiddleware {
+	return func(next AggregateHandler) AggregateHandler {
+		return snapshotHandler{next: next, eventStore: eventStore, store: store, policy: policy}
+	}
+}
+
+type snapshotHandler struct {
+	next       AggregateHandler
+	eventStore eh.EventStore
+	store      eh.SnapshotStore
+	policy     eh.SnapshotPolicy
+}
+
+// Load implements the AggregateHandler interface.
+func (h snapshotHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	codec, ok := eh.SnapshotCodecFor(aggregateType)
+	if !ok {
+		return h.next.Load(ctx, aggregateType, id)
+	}
+
+	snapshot, err := h.store.Load(ctx, aggregateType, id, eh.MaxVersion)
+	if err != nil {
+		if errors.Is(err, eh.ErrSnapshotNotFound) {
+			return h.next.Load(ctx, aggregateType, id)
+		}
+		return nil, err
+	}
+
+	aggregate, err := codec.Restore(id, snapshot.Data)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < snapshot.Version; i++ {
+		aggregate.IncrementVersion()
+	}
+
+	stream := eh.StreamNameFor(aggregateType, id)
+	events, err := h.eventStore.LoadFrom(ctx, stream, snapshot.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		if err := eh.ApplyEvent(ctx, aggregate, event); err != nil {
+			return nil, err
+		}
+		aggregate.IncrementVersion()
+	}
+
+	return aggregate, nil
+}
+
+// Save implements the AggregateHandler interface.
+//
+// A failure while persisting the snapshot below happens after h.next.Save
+// has already committed the events, so it is never returned as a plain
+// error -- that would be indistinguishable from "nothing was saved" and
+// could cause a caller such as middleware.Retry to resubmit the command,
+// producing a duplicate event. It is instead wrapped in
+// *eh.ErrPostCommitFailure.
+func (h snapshotHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	events := aggregate.UncommittedEvents()
+
+	if err := h.next.Save(ctx, aggregate, expectedVersion); err != nil {
+		return err
+	}
+
+	snapshotter, ok := aggregate.(eh.Snapshotter)
+	if !ok || h.policy == nil || !h.policy.ShouldSnapshot(aggregate, events) {
+		return nil
+	}
+
+	data, err := snapshotter.SnapshotState()
+	if err != nil {
+		return &eh.ErrPostCommitFailure{
+			AggregateType: aggregate.AggregateType(),
+			ID:            aggregate.AggregateID(),
+			Err:           err,
+		}
+	}
+
+	if err := h.store.Save(ctx, eh.Snapshot{
+		AggregateType: aggregate.AggregateType(),
+		AggregateID:   aggregate.AggregateID(),
+		Version:       aggregate.Version(),
+		Data:          data,
+	}); err != nil {
+		return &eh.ErrPostCommitFailure{
+			AggregateType: aggregate.AggregateType(),
+			ID:            aggregate.AggregateID(),
+			Err:           err,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+func init() {
+	eh.RegisterSnapshotCodec(counterAggregateType, counterSnapshotCodec{})
+}
+
+// unreachableHandler fails the test if Load or Save ever reaches it, for
+// asserting that snapshotHandler.Load serves entirely from the snapshot plus
+// event store without falling through to the wrapped handler.
+type unreachableHandler struct{ t *testing.T }
+
+func (h unreachableHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	h.t.Fatal("next.Load should not be called when a snapshot is available")
+	return nil, nil
+}
+
+func (h unreachableHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	h.t.Fatal("next.Save should not be called by snapshotHandler.Load")
+	return nil
+}
+
+func TestSnapshot_Load_RestoresFromSnapshotAndReplaysTail(t *testing.T) {
+	ctx := context.Background()
+	eventStore := newInMemoryEventStore()
+	snapshots := eh.NewInMemorySnapshotStore()
+
+	id := eh.NewUUID()
+	stream := eh.StreamNameFor(counterAggregateType, id)
+
+	seed := []eh.Event{
+		eh.NewEvent(counterIncrementedType, counterIncremented{By: 10}, id),
+	}
+	if err := eventStore.Save(ctx, stream, seed, 0); err != nil {
+		t.Fatalf("seed Save: %v", err)
+	}
+	if err := snapshots.Save(ctx, eh.Snapshot{
+		AggregateType: counterAggregateType,
+		AggregateID:   id,
+		Version:       1,
+		Data:          []byte{100},
+	}); err != nil {
+		t.Fatalf("snapshot Save: %v", err)
+	}
+	tail := []eh.Event{
+		eh.NewEvent(counterIncrementedType, counterIncremented{By: 7}, id),
+	}
+	if err := eventStore.Save(ctx, stream, tail, 1); err != nil {
+		t.Fatalf("tail Save: %v", err)
+	}
+
+	h := Snapshot(eventStore, snapshots, nil)(unreachableHandler{t: t})
+
+	aggregate, err := h.Load(ctx, counterAggregateType, id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	got := aggregate.(*counterAggregate)
+	if got.count != 107 {
+		t.Errorf("count = %d, want 107 (snapshot 100 + replayed tail 7, not seed 10+7)", got.count)
+	}
+	if got.Version() != 2 {
+		t.Errorf("Version() = %d, want 2", got.Version())
+	}
+}
+
+func TestSnapshot_Save_PersistsSnapshotWhenPolicyMatches(t *testing.T) {
+	ctx := context.Background()
+	eventStore := newInMemoryEventStore()
+	snapshots := eh.NewInMemorySnapshotStore()
+
+	next := &conflictingHandler{stored: &counterAggregate{id: eh.NewUUID()}}
+	h := Snapshot(eventStore, snapshots, eh.EveryNEvents(1))(next)
+
+	id := next.stored.AggregateID()
+	aggregate, err := next.Load(ctx, counterAggregateType, id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := aggregate.HandleCommand(ctx, nil); err != nil {
+		t.Fatalf("HandleCommand: %v", err)
+	}
+
+	if err := h.Save(ctx, aggregate, 0); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	snapshot, err := snapshots.Load(ctx, counterAggregateType, id, eh.MaxVersion)
+	if err != nil {
+		t.Fatalf("snapshot Load: %v", err)
+	}
+	if snapshot.Version != 1 {
+		t.Errorf("snapshot.Version = %d, want 1", snapshot.Version)
+	}
+	if len(snapshot.Data) != 1 || snapshot.Data[0] != 1 {
+		t.Errorf("snapshot.Data = %v, want [1]", snapshot.Data)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// Span is a single unit of traced work, as started by a Tracer.
+type Span interface {
+	// SetAttribute records a single key/value attribute on the span.
+	SetAttribute(key string, value interface{})
+	// End marks the span as finished.
+	End()
+}
+
+// Tracer starts spans for aggregate loads and saves. It is a minimal seam
+// over whatever tracing backend the caller uses (OpenTelemetry, a vendor
+// SDK, or something in-house), so this package has no tracing dependency of
+// its own.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Tracing returns an AggregateMiddleware that emits one span per Load,
+// tagged with the loaded aggregate's Version() as its event count (events
+// restored from a snapshot count as well as events replayed), and one span
+// per Save, tagged with the number of uncommitted events passed to Save,
+// whether or not Save succeeds.
+func Tracing(tracer Tracer) AggregateMiddleware {
+	return func(next AggregateHandler) AggregateHandler {
+		return tracingHandler{next: next, tracer: tracer}
+	}
+}
+
+type tracingHandler struct {
+	next   AggregateHandler
+	tracer Tracer
+}
+
+// Load implements the AggregateHandler interface.
+func (h tracingHandler) Load(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	ctx, span := h.tracer.Start(ctx, "eventhorizon.aggregate.load")
+	defer span.End()
+
+	span.SetAttribute("aggregate_type", string(aggregateType))
+	span.SetAttribute("aggregate_id", id.String())
+
+	aggregate, err := h.next.Load(ctx, aggregateType, id)
+	if aggregate != nil {
+		span.SetAttribute("event_count", aggregate.Version())
+	}
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+
+	return aggregate, err
+}
+
+// Save implements the AggregateHandler interface.
+func (h tracingHandler) Save(ctx context.Context, aggregate eh.Aggregate, expectedVersion int) error {
+	ctx, span := h.tracer.Start(ctx, "eventhorizon.aggregate.save")
+	defer span.End()
+
+	span.SetAttribute("aggregate_type", string(aggregate.AggregateType()))
+	span.SetAttribute("aggregate_id", aggregate.AggregateID().String())
+	span.SetAttribute("uncommitted_event_count", len(aggregate.UncommittedEvents()))
+
+	err := h.next.Save(ctx, aggregate, expectedVersion)
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+
+	return err
+}
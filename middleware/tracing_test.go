@@ -0,0 +1,113 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	eh "github.com/askmhs/eventhorizon"
+)
+
+// recordingSpan records the attributes set on it and whether it was ended.
+type recordingSpan struct {
+	attrs map[string]interface{}
+	ended bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordingSpan) End() {
+	s.ended = true
+}
+
+// recordingTracer hands out recordingSpans and remembers the name each was
+// started with.
+type recordingTracer struct {
+	names []string
+	spans []*recordingSpan
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordingSpan{attrs: make(map[string]interface{})}
+	tr.names = append(tr.names, name)
+	tr.spans = append(tr.spans, span)
+	return ctx, span
+}
+
+func TestTracing_Save_RecordsSpanAndForwardsResult(t *testing.T) {
+	next := &erroringHandler{err: errors.New("boom")}
+	tracer := &recordingTracer{}
+	h := Tracing(tracer)(next)
+
+	aggregate := &counterAggregate{id: eh.NewUUID()}
+	if err := aggregate.HandleCommand(context.Background(), nil); err != nil {
+		t.Fatalf("HandleCommand: %v", err)
+	}
+
+	err := h.Save(context.Background(), aggregate, 0)
+	if !errors.Is(err, next.err) {
+		t.Fatalf("Save err = %v, want %v", err, next.err)
+	}
+
+	if len(tracer.spans) != 1 || tracer.names[0] != "eventhorizon.aggregate.save" {
+		t.Fatalf("tracer.names = %v, want one [eventhorizon.aggregate.save]", tracer.names)
+	}
+	span := tracer.spans[0]
+	if !span.ended {
+		t.Error("span was not ended")
+	}
+	if span.attrs["aggregate_id"] != aggregate.AggregateID().String() {
+		t.Errorf("aggregate_id attribute = %v, want %v", span.attrs["aggregate_id"], aggregate.AggregateID().String())
+	}
+	if span.attrs["uncommitted_event_count"] != 1 {
+		t.Errorf("uncommitted_event_count attribute = %v, want 1", span.attrs["uncommitted_event_count"])
+	}
+	if span.attrs["error"] != next.err.Error() {
+		t.Errorf("error attribute = %v, want %v", span.attrs["error"], next.err.Error())
+	}
+}
+
+func TestTracing_Load_RecordsEventCountOnSuccess(t *testing.T) {
+	id := eh.NewUUID()
+	next := &conflictingHandler{stored: &counterAggregate{id: id, version: 3, count: 30}}
+	tracer := &recordingTracer{}
+	h := Tracing(tracer)(next)
+
+	aggregate, err := h.Load(context.Background(), counterAggregateType, id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if aggregate.Version() != 3 {
+		t.Fatalf("Version() = %d, want 3", aggregate.Version())
+	}
+
+	if len(tracer.spans) != 1 || tracer.names[0] != "eventhorizon.aggregate.load" {
+		t.Fatalf("tracer.names = %v, want one [eventhorizon.aggregate.load]", tracer.names)
+	}
+	span := tracer.spans[0]
+	if !span.ended {
+		t.Error("span was not ended")
+	}
+	if span.attrs["event_count"] != 3 {
+		t.Errorf("event_count attribute = %v, want 3", span.attrs["event_count"])
+	}
+	if _, ok := span.attrs["error"]; ok {
+		t.Errorf("error attribute should not be set on success, got %v", span.attrs["error"])
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import "fmt"
+
+// ErrPostCommitFailure is returned by Repository.Save when an aggregate's
+// events were successfully committed to the event store, but a step that
+// runs after the commit -- persisting a snapshot, or publishing to an
+// EventBus -- failed. Unlike every other error Save can return, the events
+// ARE already durable: a caller (or middleware.Retry's ReapplyFunc) must not
+// treat this as "nothing was saved" and re-run the command, since that would
+// commit a second, duplicate event for the same attempt.
+//
+// Use errors.As to detect it and decide how to handle the underlying
+// failure (e.g. log and continue, or schedule a snapshot/publish retry)
+// without re-running the command.
+type ErrPostCommitFailure struct {
+	// AggregateType is the type of the aggregate whose events committed.
+	AggregateType AggregateType
+	// ID is the id of the aggregate whose events committed.
+	ID UUID
+	// Err is the underlying error from the snapshot or publish step.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ErrPostCommitFailure) Error() string {
+	return fmt.Sprintf("eventhorizon: events committed for %s %s, but a post-commit step failed: %v",
+		e.AggregateType, e.ID, e.Err)
+}
+
+// Unwrap allows errors.Is/errors.As to see through to Err.
+func (e *ErrPostCommitFailure) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// failingSnapshotStore always fails Save, to exercise EventStoreRepository.Save's
+// handling of a post-commit snapshot failure.
+type failingSnapshotStore struct{ err error }
+
+func (s failingSnapshotStore) Save(ctx context.Context, snapshot Snapshot) error {
+	return s.err
+}
+
+func (s failingSnapshotStore) Load(ctx context.Context, aggregateType AggregateType, id UUID, maxVersion int) (Snapshot, error) {
+	return Snapshot{}, ErrSnapshotNotFound
+}
+
+func (s failingSnapshotStore) Delete(ctx context.Context, aggregateType AggregateType, id UUID) error {
+	return nil
+}
+
+// failingEventBus always fails PublishEvent.
+type failingEventBus struct{ err error }
+
+func (b failingEventBus) PublishEvent(ctx context.Context, stream string, event Event) error {
+	return b.err
+}
+
+func TestEventStoreRepository_Save_WrapsPostCommitSnapshotFailure(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+
+	snapErr := errors.New("snapshot store unavailable")
+	repo.SetSnapshotStore(failingSnapshotStore{err: snapErr})
+	repo.SetSnapshotPolicy(EveryNEvents(1))
+
+	id := NewUUID()
+	aggregate := &testCounterAggregate{id: id}
+	aggregate.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 1})
+	aggregate.IncrementVersion()
+
+	err := repo.Save(ctx, aggregate, 0)
+
+	var postCommit *ErrPostCommitFailure
+	if !errors.As(err, &postCommit) {
+		t.Fatalf("expected *ErrPostCommitFailure, got %T: %v", err, err)
+	}
+	if !errors.Is(postCommit.Err, snapErr) {
+		t.Errorf("postCommit.Err = %v, want %v", postCommit.Err, snapErr)
+	}
+
+	// The events must still be committed -- Save must not lose them just
+	// because the post-commit snapshot step failed.
+	stream := StreamNameFor(testCounterAggregateType, id)
+	events, loadErr := store.Load(ctx, stream)
+	if loadErr != nil {
+		t.Fatalf("Load: %v", loadErr)
+	}
+	if len(events) != 1 {
+		t.Fatalf("committed events = %d, want 1", len(events))
+	}
+
+	// A versionConflictError-only check (as middleware.Retry performs) must
+	// not mistake this for a retryable conflict.
+	if _, ok := err.(interface{ VersionConflict() bool }); ok {
+		t.Error("ErrPostCommitFailure must not satisfy the VersionConflict duck type")
+	}
+}
+
+func TestEventStoreRepository_Save_WrapsPostCommitPublishFailure(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+
+	busErr := errors.New("event bus unavailable")
+	repo.SetEventBus(failingEventBus{err: busErr})
+
+	id := NewUUID()
+	aggregate := &testCounterAggregate{id: id}
+	aggregate.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 1})
+	aggregate.IncrementVersion()
+
+	err := repo.Save(ctx, aggregate, 0)
+
+	var postCommit *ErrPostCommitFailure
+	if !errors.As(err, &postCommit) {
+		t.Fatalf("expected *ErrPostCommitFailure, got %T: %v", err, err)
+	}
+	if !errors.Is(postCommit.Err, busErr) {
+		t.Errorf("postCommit.Err = %v, want %v", postCommit.Err, busErr)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// maxInt is used as the upper version bound when loading the latest
+// snapshot for an aggregate, regardless of its current version.
+const maxInt = math.MaxInt32
+
+// Repository loads and saves aggregates, using an EventStore for the
+// underlying event persistence. It is used by the Dispatcher to provide
+// aggregates with the commands they should handle.
+type Repository interface {
+	// Load loads the most recent version of an aggregate, replaying events
+	// from the event store. Load returns ErrAggregateSoftDeleted if the
+	// aggregate implements SoftDeleter and is currently deleted, unless
+	// IncludeDeleted(true) is passed.
+	Load(ctx context.Context, aggregateType AggregateType, id UUID, options ...LoadOption) (Aggregate, error)
+
+	// Save appends the aggregate's uncommitted events to the event store,
+	// first checking that expectedVersion matches the version the aggregate
+	// had before its uncommitted events were applied (the version it was
+	// loaded at). Pass AnyVersion to skip the check. A mismatch returns
+	// ErrVersionConflict.
+	Save(ctx context.Context, aggregate Aggregate, expectedVersion int) error
+}
+
+// EventStoreRepository is a Repository that loads and saves aggregates by
+// replaying and appending events to an EventStore. Snapshotting is optional:
+// Load restores from snapshots once a SnapshotStore is set, and Save writes
+// new snapshots only once a SnapshotPolicy is set as well.
+type EventStoreRepository struct {
+	eventStore     EventStore
+	snapshotStore  SnapshotStore
+	snapshotPolicy SnapshotPolicy
+	eventBus       EventBus
+}
+
+// NewEventStoreRepository creates a EventStoreRepository backed by store.
+func NewEventStoreRepository(store EventStore) *EventStoreRepository {
+	return &EventStoreRepository{
+		eventStore: store,
+	}
+}
+
+// SetSnapshotStore configures the SnapshotStore used to read and write
+// aggregate snapshots. Load reads snapshots from it as soon as it is set;
+// Save writes new snapshots to it only once a SnapshotPolicy is also set via
+// SetSnapshotPolicy.
+func (r *EventStoreRepository) SetSnapshotStore(store SnapshotStore) {
+	r.snapshotStore = store
+}
+
+// SetSnapshotPolicy configures the SnapshotPolicy that decides whether a new
+// snapshot should be persisted after Save commits an aggregate's events.
+func (r *EventStoreRepository) SetSnapshotPolicy(policy SnapshotPolicy) {
+	r.snapshotPolicy = policy
+}
+
+// Load implements the Load method of the Repository interface.
+//
+// If the aggregate type has a SnapshotCodec registered and r.snapshotStore is
+// set, Load first reads the latest snapshot at or below the aggregate's
+// current version, restores it, and only replays events with a version
+// greater than the snapshot's. Each event is replayed via applyEvent, which
+// checks its version against the aggregate's for aggregates embedding
+// *AggregateBase (see SafeApplyEvent).
+func (r *EventStoreRepository) Load(ctx context.Context, aggregateType AggregateType, id UUID, options ...LoadOption) (Aggregate, error) {
+	aggregate, fromVersion, err := r.restoreFromSnapshot(ctx, aggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+	if aggregate == nil {
+		aggregate, err = CreateAggregate(aggregateType, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stream := streamNamerFor(aggregateType).StreamName(aggregateType, id)
+	events, err := r.eventStore.LoadFrom(ctx, stream, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		if err := applyEvent(ctx, aggregate, event); err != nil {
+			return nil, err
+		}
+		aggregate.IncrementVersion()
+	}
+
+	opts := NewLoadOptions(options...)
+	if !opts.includeDeleted {
+		if deleter, ok := aggregate.(SoftDeleter); ok {
+			if deleted, _ := deleter.Deleted(); deleted {
+				return nil, ErrAggregateSoftDeleted
+			}
+		}
+	}
+
+	return aggregate, nil
+}
+
+// restoreFromSnapshot loads and applies the latest available snapshot for
+// the aggregate, if snapshotting is configured for its type. It returns the
+// restored aggregate (or nil if none applies) and the version from which
+// event replay should continue.
+func (r *EventStoreRepository) restoreFromSnapshot(ctx context.Context, aggregateType AggregateType, id UUID) (Aggregate, int, error) {
+	if r.snapshotStore == nil {
+		return nil, 0, nil
+	}
+
+	codec, ok := snapshotCodecFor(aggregateType)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	snapshot, err := r.snapshotStore.Load(ctx, aggregateType, id, maxInt)
+	if err != nil {
+		if errors.Is(err, ErrSnapshotNotFound) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	aggregate, err := codec.Restore(id, snapshot.Data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := 0; i < snapshot.Version; i++ {
+		aggregate.IncrementVersion()
+	}
+
+	return aggregate, snapshot.Version, nil
+}
+
+// Save implements the Save method of the Repository interface.
+//
+// After the events commit, if snapshotting is configured and the aggregate
+// is a Snapshotter, the SnapshotPolicy is consulted to decide whether a new
+// snapshot should be persisted. A failure in that snapshot step, or in
+// publishing to the EventBus, does not mean the events were lost -- they are
+// already durably committed by this point -- so it is never returned as a
+// plain error. Instead Save returns it wrapped in *ErrPostCommitFailure,
+// which callers (and middleware.Retry in particular) must not treat as "the
+// command was not applied" and re-run.
+func (r *EventStoreRepository) Save(ctx context.Context, aggregate Aggregate, expectedVersion int) error {
+	events := aggregate.UncommittedEvents()
+	if len(events) == 0 {
+		return nil
+	}
+
+	originalVersion := aggregate.Version() - len(events)
+	if expectedVersion != AnyVersion && expectedVersion != originalVersion {
+		return ErrVersionConflict{
+			AggregateType: aggregate.AggregateType(),
+			ID:            aggregate.AggregateID(),
+			Expected:      expectedVersion,
+			Actual:        originalVersion,
+		}
+	}
+
+	stream := streamNamerFor(aggregate.AggregateType()).StreamName(aggregate.AggregateType(), aggregate.AggregateID())
+	if err := r.eventStore.Save(ctx, stream, events, originalVersion); err != nil {
+		return err
+	}
+
+	aggregate.ClearUncommittedEvents()
+
+	var postCommitErr error
+	if err := r.maybeSnapshot(ctx, aggregate, events); err != nil {
+		postCommitErr = err
+	}
+	if err := r.publish(ctx, stream, events); err != nil {
+		postCommitErr = errors.Join(postCommitErr, err)
+	}
+
+	if postCommitErr != nil {
+		return &ErrPostCommitFailure{
+			AggregateType: aggregate.AggregateType(),
+			ID:            aggregate.AggregateID(),
+			Err:           postCommitErr,
+		}
+	}
+
+	return nil
+}
+
+// publish forwards committed events to the configured EventBus, if any, so
+// that downstream projections subscribed to the aggregate's stream (or, via
+// a category StreamNamer, to every instance of its type) receive them.
+func (r *EventStoreRepository) publish(ctx context.Context, stream string, events []Event) error {
+	if r.eventBus == nil {
+		return nil
+	}
+	for _, event := range events {
+		if err := r.eventBus.PublishEvent(ctx, stream, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetEventBus configures the EventBus that committed events are published
+// to after Save, keyed by the aggregate's stream name as produced by its
+// StreamNamer.
+func (r *EventStoreRepository) SetEventBus(bus EventBus) {
+	r.eventBus = bus
+}
+
+// maybeSnapshot persists a new snapshot of aggregate if snapshotting is
+// configured and the policy decides to do so for the just-committed events.
+func (r *EventStoreRepository) maybeSnapshot(ctx context.Context, aggregate Aggregate, committed []Event) error {
+	if r.snapshotStore == nil || r.snapshotPolicy == nil {
+		return nil
+	}
+
+	snapshotter, ok := aggregate.(Snapshotter)
+	if !ok {
+		return nil
+	}
+
+	if !r.snapshotPolicy.ShouldSnapshot(aggregate, committed) {
+		return nil
+	}
+
+	data, err := snapshotter.SnapshotState()
+	if err != nil {
+		return err
+	}
+
+	return r.snapshotStore.Save(ctx, Snapshot{
+		AggregateType: aggregate.AggregateType(),
+		AggregateID:   aggregate.AggregateID(),
+		Version:       aggregate.Version(),
+		Data:          data,
+	})
+}
+
+// EventStore is the interface for an event storage backend, appending and
+// loading the events of a named stream, as produced by a StreamNamer. By
+// default a stream holds the events of a single aggregate instance, but a
+// custom StreamNamer can map many aggregates onto the same stream.
+type EventStore interface {
+	// Save appends events to stream. originalVersion is the aggregate
+	// version before the events were applied, used for optimistic
+	// concurrency control by implementations.
+	Save(ctx context.Context, stream string, events []Event, originalVersion int) error
+
+	// Load loads all events for a stream.
+	Load(ctx context.Context, stream string) ([]Event, error)
+
+	// LoadFrom loads all events for a stream with a version greater than
+	// fromVersion, so a Repository can replay only the events that happened
+	// after a snapshot.
+	LoadFrom(ctx context.Context, stream string, fromVersion int) ([]Event, error)
+}
+
+// EventBus publishes committed events so that projections and other
+// subscribers can react to them. Publication is keyed by stream name, so
+// subscribers can use a category StreamNamer to consume every instance of
+// an aggregate type.
+type EventBus interface {
+	// PublishEvent publishes event as having occurred on stream.
+	PublishEvent(ctx context.Context, stream string, event Event) error
+}
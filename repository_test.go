@@ -0,0 +1,247 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// inMemoryEventStore is a minimal EventStore test double backed by a map of
+// streams to committed events. Save enforces the same optimistic-concurrency
+// contract a real backend would: originalVersion must match the number of
+// events already committed to the stream.
+type inMemoryEventStore struct {
+	mu      sync.Mutex
+	streams map[string][]Event
+}
+
+func newInMemoryEventStore() *inMemoryEventStore {
+	return &inMemoryEventStore{streams: make(map[string][]Event)}
+}
+
+func (s *inMemoryEventStore) Save(ctx context.Context, stream string, events []Event, originalVersion int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.streams[stream]
+	if len(existing) != originalVersion {
+		return fmt.Errorf("inMemoryEventStore: concurrent write to %q: expected version %d, actual %d",
+			stream, originalVersion, len(existing))
+	}
+	s.streams[stream] = append(existing, events...)
+	return nil
+}
+
+func (s *inMemoryEventStore) Load(ctx context.Context, stream string) ([]Event, error) {
+	return s.LoadFrom(ctx, stream, 0)
+}
+
+func (s *inMemoryEventStore) LoadFrom(ctx context.Context, stream string, fromVersion int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.streams[stream]
+	if fromVersion >= len(all) {
+		return nil, nil
+	}
+	events := make([]Event, len(all)-fromVersion)
+	copy(events, all[fromVersion:])
+	return events, nil
+}
+
+// testCounterIncremented is the only event testCounterAggregate knows how to
+// apply: it adds By to the running count.
+type testCounterIncremented struct {
+	By int
+}
+
+const (
+	testCounterAggregateType   = AggregateType("RepositoryTestCounter")
+	testCounterIncrementedType = EventType("Incremented")
+)
+
+// testCounterAggregate is a hand-written Aggregate (it does not embed
+// *AggregateBase) used to drive EventStoreRepository without pulling in
+// reflection-based dispatch or SafeApplyEvent's version-sequencing check --
+// see applyEvent's documented fallback to a plain ApplyEvent call for
+// aggregates that don't need it.
+type testCounterAggregate struct {
+	id      UUID
+	version int
+	count   int
+	events  []Event
+}
+
+func newTestCounterAggregate(id UUID) Aggregate {
+	return &testCounterAggregate{id: id}
+}
+
+func (a *testCounterAggregate) AggregateType() AggregateType { return testCounterAggregateType }
+func (a *testCounterAggregate) AggregateID() UUID             { return a.id }
+func (a *testCounterAggregate) Version() int                  { return a.version }
+func (a *testCounterAggregate) IncrementVersion()              { a.version++ }
+
+func (a *testCounterAggregate) HandleCommand(ctx context.Context, cmd Command) error {
+	return nil
+}
+
+func (a *testCounterAggregate) StoreEvent(t EventType, data EventData) Event {
+	e := NewEvent(t, data, a.id)
+	a.events = append(a.events, e)
+	return e
+}
+
+func (a *testCounterAggregate) UncommittedEvents() []Event { return a.events }
+func (a *testCounterAggregate) ClearUncommittedEvents()     { a.events = nil }
+
+func (a *testCounterAggregate) ApplyEvent(ctx context.Context, event Event) error {
+	data, ok := event.Data().(testCounterIncremented)
+	if !ok {
+		return fmt.Errorf("testCounterAggregate: unexpected event data %T", event.Data())
+	}
+	a.count += data.By
+	return nil
+}
+
+// SnapshotState implements the Snapshotter interface, encoding count as a
+// single byte -- enough range for these tests.
+func (a *testCounterAggregate) SnapshotState() ([]byte, error) {
+	return []byte{byte(a.count)}, nil
+}
+
+// RestoreSnapshot implements the Snapshotter interface.
+func (a *testCounterAggregate) RestoreSnapshot(data []byte) error {
+	a.count = int(data[0])
+	return nil
+}
+
+type testCounterSnapshotCodec struct{}
+
+func (testCounterSnapshotCodec) Restore(id UUID, data []byte) (Aggregate, error) {
+	a := &testCounterAggregate{id: id}
+	if err := a.RestoreSnapshot(data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func init() {
+	RegisterAggregate(newTestCounterAggregate)
+	RegisterSnapshotCodec(testCounterAggregateType, testCounterSnapshotCodec{})
+}
+
+func TestEventStoreRepository_Load_RestoresFromSnapshotAndReplaysTail(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+	snapshots := NewInMemorySnapshotStore()
+	repo.SetSnapshotStore(snapshots)
+
+	id := NewUUID()
+	stream := StreamNameFor(testCounterAggregateType, id)
+
+	// Two events already folded into the snapshot below; Load must not
+	// replay these, only the tail committed after the snapshot's version.
+	seed := []Event{
+		NewEvent(testCounterIncrementedType, testCounterIncremented{By: 10}, id),
+		NewEvent(testCounterIncrementedType, testCounterIncremented{By: 20}, id),
+	}
+	if err := store.Save(ctx, stream, seed, 0); err != nil {
+		t.Fatalf("seed Save: %v", err)
+	}
+
+	if err := snapshots.Save(ctx, Snapshot{
+		AggregateType: testCounterAggregateType,
+		AggregateID:   id,
+		Version:       2,
+		Data:          []byte{200},
+	}); err != nil {
+		t.Fatalf("snapshot Save: %v", err)
+	}
+
+	tail := []Event{
+		NewEvent(testCounterIncrementedType, testCounterIncremented{By: 5}, id),
+	}
+	if err := store.Save(ctx, stream, tail, 2); err != nil {
+		t.Fatalf("tail Save: %v", err)
+	}
+
+	aggregate, err := repo.Load(ctx, testCounterAggregateType, id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	got := aggregate.(*testCounterAggregate)
+	if got.count != 205 {
+		t.Errorf("count = %d, want 205 (snapshot 200 + replayed tail 5, not seed 10+20+5)", got.count)
+	}
+	if got.Version() != 3 {
+		t.Errorf("Version() = %d, want 3", got.Version())
+	}
+}
+
+func TestEventStoreRepository_Save_RejectsMismatchedExpectedVersion(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+
+	id := NewUUID()
+	aggregate := &testCounterAggregate{id: id, version: 3}
+	aggregate.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 1})
+	aggregate.IncrementVersion()
+
+	err := repo.Save(ctx, aggregate, 2)
+	if err == nil {
+		t.Fatal("expected ErrVersionConflict, got nil")
+	}
+	conflict, ok := err.(ErrVersionConflict)
+	if !ok {
+		t.Fatalf("expected ErrVersionConflict, got %T: %v", err, err)
+	}
+	if conflict.Expected != 2 || conflict.Actual != 3 {
+		t.Errorf("conflict = %+v, want Expected=2 Actual=3", conflict)
+	}
+}
+
+func TestEventStoreRepository_Save_ConcurrentWritersConflict(t *testing.T) {
+	ctx := context.Background()
+	store := newInMemoryEventStore()
+	repo := NewEventStoreRepository(store)
+
+	id := NewUUID()
+
+	// Two independently loaded copies of the same aggregate, as if two
+	// goroutines both loaded it at version 0.
+	first := &testCounterAggregate{id: id}
+	first.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 1})
+	first.IncrementVersion()
+
+	second := &testCounterAggregate{id: id}
+	second.StoreEvent(testCounterIncrementedType, testCounterIncremented{By: 2})
+	second.IncrementVersion()
+
+	if err := repo.Save(ctx, first, 0); err != nil {
+		t.Fatalf("first Save: %v", err)
+	}
+
+	// second still believes the aggregate was at version 0 before its event,
+	// but the event store now holds first's committed event.
+	if err := repo.Save(ctx, second, 0); err == nil {
+		t.Fatal("expected second Save to fail due to the concurrent commit from first, got nil")
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Snapshotter is implemented by aggregates that can serialize and restore
+// their full state as a single blob, so a Repository can avoid replaying the
+// whole event stream on every load.
+type Snapshotter interface {
+	// SnapshotState returns a serialized representation of the aggregate's
+	// current state.
+	SnapshotState() ([]byte, error)
+	// RestoreSnapshot hydrates the aggregate's state from a blob previously
+	// returned by SnapshotState.
+	RestoreSnapshot([]byte) error
+}
+
+// SnapshotCodec produces a typed aggregate and hydrates it from a stored
+// snapshot blob, registered per AggregateType via RegisterSnapshotCodec.
+type SnapshotCodec interface {
+	// Restore creates an aggregate of the given id and applies the snapshot
+	// data to it via RestoreSnapshot.
+	Restore(id UUID, data []byte) (Aggregate, error)
+}
+
+var snapshotCodecs = make(map[AggregateType]SnapshotCodec)
+var snapshotCodecsMu sync.RWMutex
+
+// RegisterSnapshotCodec registers the SnapshotCodec used to restore
+// snapshots for aggregateType, mirroring RegisterAggregate.
+//
+// An example would be:
+//     RegisterSnapshotCodec(UserAggregateType, UserSnapshotCodec{})
+func RegisterSnapshotCodec(aggregateType AggregateType, codec SnapshotCodec) {
+	snapshotCodecsMu.Lock()
+	defer snapshotCodecsMu.Unlock()
+	if _, ok := snapshotCodecs[aggregateType]; ok {
+		panic("eventhorizon: registering duplicate snapshot codec for " + string(aggregateType))
+	}
+	snapshotCodecs[aggregateType] = codec
+}
+
+// snapshotCodecFor returns the registered SnapshotCodec for an aggregate
+// type, if any.
+func snapshotCodecFor(aggregateType AggregateType) (SnapshotCodec, bool) {
+	snapshotCodecsMu.RLock()
+	defer snapshotCodecsMu.RUnlock()
+	codec, ok := snapshotCodecs[aggregateType]
+	return codec, ok
+}
+
+// SnapshotCodecFor returns the SnapshotCodec registered via
+// RegisterSnapshotCodec for aggregateType, if any. It is exported so that
+// middleware and external stores can restore a snapshot without
+// reimplementing the registry.
+func SnapshotCodecFor(aggregateType AggregateType) (SnapshotCodec, bool) {
+	return snapshotCodecFor(aggregateType)
+}
+
+// MaxVersion is the version to pass to SnapshotStore.Load to fetch the
+// latest snapshot for an aggregate, regardless of its current version.
+const MaxVersion = maxInt
+
+// Snapshot is a single persisted snapshot of an aggregate at a given version.
+type Snapshot struct {
+	AggregateType AggregateType
+	AggregateID   UUID
+	Version       int
+	Data          []byte
+}
+
+// ErrSnapshotNotFound is returned by a SnapshotStore when no snapshot exists
+// for an aggregate.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// SnapshotStore is the interface for a snapshot storage backend. Mongo,
+// Redis or Postgres backed implementations can be added externally as long
+// as they satisfy this interface.
+type SnapshotStore interface {
+	// Save persists a snapshot, replacing any snapshot previously stored for
+	// the same aggregate type and id.
+	Save(ctx context.Context, snapshot Snapshot) error
+
+	// Load returns the latest snapshot with Version <= maxVersion for the
+	// given aggregate type and id. It returns ErrSnapshotNotFound if none
+	// exists at or below maxVersion.
+	Load(ctx context.Context, aggregateType AggregateType, id UUID, maxVersion int) (Snapshot, error)
+
+	// Delete removes any stored snapshot for the given aggregate type and id.
+	Delete(ctx context.Context, aggregateType AggregateType, id UUID) error
+}
+
+// SnapshotPolicy decides whether a new snapshot should be persisted after an
+// aggregate's events have been committed.
+type SnapshotPolicy interface {
+	// ShouldSnapshot is called after aggregate's uncommitted events have
+	// been saved, with the events that were just committed.
+	ShouldSnapshot(aggregate Aggregate, committed []Event) bool
+}
+
+// SnapshotPolicyFunc is an adapter to use ordinary functions as a
+// SnapshotPolicy.
+type SnapshotPolicyFunc func(aggregate Aggregate, committed []Event) bool
+
+// ShouldSnapshot implements the SnapshotPolicy interface.
+func (f SnapshotPolicyFunc) ShouldSnapshot(aggregate Aggregate, committed []Event) bool {
+	return f(aggregate, committed)
+}
+
+// EveryNEvents returns a SnapshotPolicy that snapshots once the aggregate's
+// version is a multiple of n.
+func EveryNEvents(n int) SnapshotPolicy {
+	return SnapshotPolicyFunc(func(aggregate Aggregate, committed []Event) bool {
+		if n <= 0 {
+			return false
+		}
+		return aggregate.Version()%n == 0
+	})
+}
+
+// OnEventTypes returns a SnapshotPolicy that snapshots whenever one of the
+// committed events matches one of the given event types.
+func OnEventTypes(types ...EventType) SnapshotPolicy {
+	set := make(map[EventType]bool, len(types))
+	for _, t := range types {
+		set[t] = true
+	}
+	return SnapshotPolicyFunc(func(aggregate Aggregate, committed []Event) bool {
+		for _, e := range committed {
+			if set[e.EventType()] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// InMemorySnapshotStore is an in-memory implementation of SnapshotStore,
+// useful for tests and examples.
+type InMemorySnapshotStore struct {
+	snapshots map[AggregateType]map[UUID]Snapshot
+	mu        sync.RWMutex
+}
+
+// NewInMemorySnapshotStore creates an InMemorySnapshotStore.
+func NewInMemorySnapshotStore() *InMemorySnapshotStore {
+	return &InMemorySnapshotStore{
+		snapshots: make(map[AggregateType]map[UUID]Snapshot),
+	}
+}
+
+// Save implements the Save method of the SnapshotStore interface.
+func (s *InMemorySnapshotStore) Save(ctx context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byID, ok := s.snapshots[snapshot.AggregateType]
+	if !ok {
+		byID = make(map[UUID]Snapshot)
+		s.snapshots[snapshot.AggregateType] = byID
+	}
+	byID[snapshot.AggregateID] = snapshot
+	return nil
+}
+
+// Load implements the Load method of the SnapshotStore interface.
+func (s *InMemorySnapshotStore) Load(ctx context.Context, aggregateType AggregateType, id UUID, maxVersion int) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byID, ok := s.snapshots[aggregateType]
+	if !ok {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	snapshot, ok := byID[id]
+	if !ok || snapshot.Version > maxVersion {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	return snapshot, nil
+}
+
+// Delete implements the Delete method of the SnapshotStore interface.
+func (s *InMemorySnapshotStore) Delete(ctx context.Context, aggregateType AggregateType, id UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if byID, ok := s.snapshots[aggregateType]; ok {
+		delete(byID, id)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAggregateSoftDeleted is returned when loading an aggregate that has been
+// soft-deleted, unless the load is explicitly allowed via IncludeDeleted.
+var ErrAggregateSoftDeleted = errors.New("aggregate is soft-deleted")
+
+// SoftDeleter is implemented by aggregates that support soft-deletion. An
+// aggregate reports whether it is currently deleted and, if so, when the
+// deletion occurred.
+type SoftDeleter interface {
+	// Deleted returns whether the aggregate is currently soft-deleted, and if
+	// so, the time at which the deletion occurred.
+	Deleted() (bool, time.Time)
+}
+
+// SoftRestorer is implemented by aggregates that can be restored after being
+// soft-deleted.
+type SoftRestorer interface {
+	// Restore marks the aggregate as restored, clearing the deleted state.
+	Restore()
+}
+
+// LoadOptions holds options for loading an aggregate from a Repository.
+type LoadOptions struct {
+	// includeDeleted makes Load return a soft-deleted aggregate instead of
+	// ErrAggregateSoftDeleted.
+	includeDeleted bool
+}
+
+// LoadOption is used to configure LoadOptions when loading an aggregate.
+type LoadOption func(*LoadOptions)
+
+// IncludeDeleted configures a load to return a soft-deleted aggregate instead
+// of failing with ErrAggregateSoftDeleted.
+func IncludeDeleted(include bool) LoadOption {
+	return func(o *LoadOptions) {
+		o.includeDeleted = include
+	}
+}
+
+// NewLoadOptions creates a LoadOptions from a list of LoadOption.
+func NewLoadOptions(options ...LoadOption) LoadOptions {
+	o := LoadOptions{}
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// softDeleteEvents maps each aggregate type to the well-known event that
+// marks its aggregates as deleted, registered via RegisterSoftDeleteEvent.
+var softDeleteEvents = make(map[AggregateType]EventType)
+var softDeleteEventsMu sync.RWMutex
+
+// RegisterSoftDeleteEvent registers the event type that marks aggregates of
+// aggregateType as soft-deleted when applied. AggregateBase uses this
+// registration to track deleted state without user aggregates having to
+// hand-roll SoftDeleter/SoftRestorer themselves.
+//
+// An example would be:
+//     RegisterSoftDeleteEvent(UserAggregateType, UserDeletedEvent)
+func RegisterSoftDeleteEvent(aggregateType AggregateType, eventType EventType) {
+	softDeleteEventsMu.Lock()
+	defer softDeleteEventsMu.Unlock()
+	if _, ok := softDeleteEvents[aggregateType]; ok {
+		panic("eventhorizon: registering duplicate soft-delete event for " + string(aggregateType))
+	}
+	softDeleteEvents[aggregateType] = eventType
+}
+
+// softDeleteEventFor returns the registered soft-delete event type for an
+// aggregate type, if any.
+func softDeleteEventFor(aggregateType AggregateType) (EventType, bool) {
+	softDeleteEventsMu.RLock()
+	defer softDeleteEventsMu.RUnlock()
+	t, ok := softDeleteEvents[aggregateType]
+	return t, ok
+}
+
+// AggregateBase is a mix-in that provides the common parts of the Aggregate
+// interface and tracks soft-deleted state for aggregate types registered via
+// RegisterSoftDeleteEvent, so that user aggregates embedding AggregateBase
+// get SoftDeleter/SoftRestorer behavior without hand-rolling it.
+type AggregateBase struct {
+	id                  UUID
+	t                   AggregateType
+	version             int
+	events              []Event
+	deleted             bool
+	deletedAt           time.Time
+	ignoreUnknownEvents bool
+}
+
+// NewAggregateBase creates an AggregateBase for an aggregate type and id.
+func NewAggregateBase(t AggregateType, id UUID) *AggregateBase {
+	return &AggregateBase{
+		id: id,
+		t:  t,
+	}
+}
+
+// AggregateID implements the AggregateID method of the Aggregate interface.
+func (a *AggregateBase) AggregateID() UUID {
+	return a.id
+}
+
+// AggregateType implements the AggregateType method of the Aggregate interface.
+func (a *AggregateBase) AggregateType() AggregateType {
+	return a.t
+}
+
+// Version implements the Version method of the Aggregate interface.
+func (a *AggregateBase) Version() int {
+	return a.version
+}
+
+// IncrementVersion implements the IncrementVersion method of the Aggregate
+// interface.
+func (a *AggregateBase) IncrementVersion() {
+	a.version++
+}
+
+// StoreEvent implements the StoreEvent method of the Aggregate interface.
+func (a *AggregateBase) StoreEvent(t EventType, data EventData) Event {
+	e := NewEvent(t, data, a.id)
+	a.events = append(a.events, e)
+	return e
+}
+
+// UncommittedEvents implements the UncommittedEvents method of the Aggregate
+// interface.
+func (a *AggregateBase) UncommittedEvents() []Event {
+	return a.events
+}
+
+// ClearUncommittedEvents implements the ClearUncommittedEvents method of the
+// Aggregate interface.
+func (a *AggregateBase) ClearUncommittedEvents() {
+	a.events = nil
+}
+
+// Deleted implements the SoftDeleter interface. It only reports a deleted
+// state for aggregate types with a soft-delete event registered via
+// RegisterSoftDeleteEvent.
+func (a *AggregateBase) Deleted() (bool, time.Time) {
+	return a.deleted, a.deletedAt
+}
+
+// Restore implements the SoftRestorer interface.
+func (a *AggregateBase) Restore() {
+	a.deleted = false
+	a.deletedAt = time.Time{}
+}
+
+// ApplySoftDeleteEvent updates the deleted state based on the registered
+// soft-delete event for this aggregate's type. Concrete aggregates embedding
+// AggregateBase should call this from their ApplyEvent before handling the
+// event themselves.
+func (a *AggregateBase) ApplySoftDeleteEvent(event Event) {
+	if t, ok := softDeleteEventFor(a.t); ok && event.EventType() == t {
+		a.deleted = true
+		a.deletedAt = event.Timestamp()
+	}
+}
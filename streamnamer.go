@@ -0,0 +1,92 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"fmt"
+	"sync"
+)
+
+// StreamNamer maps an aggregate to the name of the event stream its events
+// are written to and read from. The default naming gives every aggregate
+// instance its own stream; a custom StreamNamer lets aggregates opt into
+// category streams, tenant-prefixed streams, or sharded streams instead.
+type StreamNamer interface {
+	// StreamName returns the name of the stream for a single aggregate
+	// instance.
+	StreamName(AggregateType, UUID) string
+
+	// CategoryStream returns the name of the stream that contains the
+	// events of every instance of aggregateType, so that subscribers can
+	// consume all instances of a type at once.
+	CategoryStream(AggregateType) string
+}
+
+// defaultStreamNamer is the StreamNamer used for aggregate types with no
+// namer registered via RegisterStreamNamer. It names per-instance streams
+// "<type>-<id>" and the category stream "<type>".
+type defaultStreamNamer struct{}
+
+// StreamName implements the StreamNamer interface.
+func (defaultStreamNamer) StreamName(aggregateType AggregateType, id UUID) string {
+	return fmt.Sprintf("%s-%s", aggregateType, id)
+}
+
+// CategoryStream implements the StreamNamer interface.
+func (defaultStreamNamer) CategoryStream(aggregateType AggregateType) string {
+	return string(aggregateType)
+}
+
+// DefaultStreamNamer is the StreamNamer used for aggregate types with no
+// namer registered via RegisterStreamNamer.
+var DefaultStreamNamer StreamNamer = defaultStreamNamer{}
+
+var streamNamers = make(map[AggregateType]StreamNamer)
+var streamNamersMu sync.RWMutex
+
+// RegisterStreamNamer registers the StreamNamer used for aggregateType's
+// event streams. Aggregate types with no namer registered fall back to
+// DefaultStreamNamer.
+//
+// An example would be:
+//     RegisterStreamNamer(TenantAggregateType, TenantStreamNamer{})
+func RegisterStreamNamer(aggregateType AggregateType, namer StreamNamer) {
+	streamNamersMu.Lock()
+	defer streamNamersMu.Unlock()
+	if _, ok := streamNamers[aggregateType]; ok {
+		panic("eventhorizon: registering duplicate stream namer for " + string(aggregateType))
+	}
+	streamNamers[aggregateType] = namer
+}
+
+// streamNamerFor returns the StreamNamer registered for aggregateType, or
+// DefaultStreamNamer if none was registered.
+func streamNamerFor(aggregateType AggregateType) StreamNamer {
+	streamNamersMu.RLock()
+	defer streamNamersMu.RUnlock()
+	if namer, ok := streamNamers[aggregateType]; ok {
+		return namer
+	}
+	return DefaultStreamNamer
+}
+
+// StreamNameFor returns the stream name for an aggregate instance, using the
+// StreamNamer registered for its type via RegisterStreamNamer (or
+// DefaultStreamNamer if none was registered). It is exported so that
+// middleware and external stores can locate an aggregate's stream without
+// reimplementing the registry.
+func StreamNameFor(aggregateType AggregateType, id UUID) string {
+	return streamNamerFor(aggregateType).StreamName(aggregateType, id)
+}
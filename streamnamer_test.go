@@ -0,0 +1,77 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import "testing"
+
+func TestStreamNameFor_DefaultStreamNamer(t *testing.T) {
+	const aggregateType = AggregateType("StreamNamerTestDefault")
+	id := NewUUID()
+
+	want := DefaultStreamNamer.StreamName(aggregateType, id)
+	if got := StreamNameFor(aggregateType, id); got != want {
+		t.Errorf("StreamNameFor = %q, want %q", got, want)
+	}
+	if wantLiteral := string(aggregateType) + "-" + id.String(); want != wantLiteral {
+		t.Errorf("default stream name = %q, want %q", want, wantLiteral)
+	}
+}
+
+// categoryStreamNamer routes every aggregate instance of a type onto the
+// same stream, keyed only by aggregate type.
+type categoryStreamNamer struct{}
+
+func (categoryStreamNamer) StreamName(aggregateType AggregateType, id UUID) string {
+	return "category-" + string(aggregateType)
+}
+
+func (categoryStreamNamer) CategoryStream(aggregateType AggregateType) string {
+	return "category-" + string(aggregateType)
+}
+
+func TestRegisterStreamNamer_OverridesDefaultForItsType(t *testing.T) {
+	const (
+		customType  = AggregateType("StreamNamerTestCustom")
+		defaultType = AggregateType("StreamNamerTestUnregistered")
+	)
+	RegisterStreamNamer(customType, categoryStreamNamer{})
+
+	idA := NewUUID()
+	idB := NewUUID()
+	if got, want := StreamNameFor(customType, idA), "category-"+string(customType); got != want {
+		t.Errorf("StreamNameFor(customType, idA) = %q, want %q", got, want)
+	}
+	if got := StreamNameFor(customType, idA); got != StreamNameFor(customType, idB) {
+		t.Errorf("category stream namer should map every instance of %s onto one stream, got %q and %q",
+			customType, got, StreamNameFor(customType, idB))
+	}
+
+	// A type with no namer registered is unaffected.
+	if got, want := StreamNameFor(defaultType, idA), DefaultStreamNamer.StreamName(defaultType, idA); got != want {
+		t.Errorf("StreamNameFor(defaultType, idA) = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterStreamNamer_DuplicatePanics(t *testing.T) {
+	const aggregateType = AggregateType("StreamNamerTestDuplicate")
+	RegisterStreamNamer(aggregateType, categoryStreamNamer{})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic registering a duplicate stream namer")
+		}
+	}()
+	RegisterStreamNamer(aggregateType, categoryStreamNamer{})
+}
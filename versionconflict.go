@@ -0,0 +1,73 @@
+// Copyright (c) 2014 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import "fmt"
+
+// AnyVersion can be passed as the expectedVersion argument to
+// Repository.Save to skip its expectedVersion check. EventStoreRepository
+// still passes the aggregate's original version to EventStore.Save, so an
+// event store that enforces it can still reject the commit.
+const AnyVersion = -1
+
+// ErrVersionConflict is returned by Repository.Save when expectedVersion
+// does not match the version the aggregate had before its uncommitted
+// events were applied. EventStoreRepository.Save does not compare against
+// the event store's last committed version itself; a commit by another
+// writer in between is rejected by EventStore.Save with the store's own
+// error, not with ErrVersionConflict.
+type ErrVersionConflict struct {
+	// AggregateType is the type of the aggregate that conflicted.
+	AggregateType AggregateType
+	// ID is the id of the aggregate that conflicted.
+	ID UUID
+	// Expected is the version the caller expected to be current.
+	Expected int
+	// Actual is the version the aggregate actually had at the time of Save.
+	Actual int
+}
+
+// Error implements the error interface.
+func (e ErrVersionConflict) Error() string {
+	return fmt.Sprintf("eventhorizon: version conflict for %s %s: expected version %d, actual %d",
+		e.AggregateType, e.ID, e.Expected, e.Actual)
+}
+
+// VersionConflict reports true, letting generic retry middleware (such as
+// middleware.Retry) recognize this error without importing this type
+// directly.
+func (e ErrVersionConflict) VersionConflict() bool {
+	return true
+}
+
+// ErrEventVersionMismatch is returned by AggregateBase.SafeApplyEvent when
+// an event's version does not immediately follow the aggregate's current
+// version, signalling a missed or out-of-order event during replay.
+type ErrEventVersionMismatch struct {
+	// AggregateType is the type of the aggregate the event was applied to.
+	AggregateType AggregateType
+	// ID is the id of the aggregate the event was applied to.
+	ID UUID
+	// Expected is the version the next event was expected to have.
+	Expected int
+	// Actual is the version the event actually had.
+	Actual int
+}
+
+// Error implements the error interface.
+func (e ErrEventVersionMismatch) Error() string {
+	return fmt.Sprintf("eventhorizon: event version mismatch for %s %s: expected version %d, got %d",
+		e.AggregateType, e.ID, e.Expected, e.Actual)
+}